@@ -0,0 +1,493 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+	jpegstructure "github.com/dsoprea/go-jpeg-image-structure/v2"
+)
+
+// defaultFieldNames is what scan/apply use when --fields isn't given, so
+// the CSV shape is unchanged from before this field table existed.
+var defaultFieldNames = []string{"title"}
+
+// fieldSpec describes how to read and write one well-known metadata
+// field across a JPEG's EXIF/IPTC/XMP blocks. read returns "" with no
+// error when the field simply isn't present anywhere in the file.
+type fieldSpec struct {
+	read  func(sl *jpegstructure.SegmentList) (string, error)
+	write func(sl *jpegstructure.SegmentList, value string) error
+}
+
+// fieldSpecs maps the keys accepted by --fields to their concrete tags.
+// Multi-valued fields (keywords) use ";" to separate values within a
+// single CSV cell.
+var fieldSpecs = map[string]fieldSpec{
+	"title": {
+		read: func(sl *jpegstructure.SegmentList) (string, error) {
+			ts, err := readTitleSetFromSegments(sl)
+			if err != nil {
+				return "", err
+			}
+			return mergeTitle(ts), nil
+		},
+		write: func(sl *jpegstructure.SegmentList, value string) error {
+			return upsertAllTitles(sl, value)
+		},
+	},
+	"description": {
+		read: func(sl *jpegstructure.SegmentList) (string, error) {
+			ts, err := readTitleSetFromSegments(sl)
+			if err != nil {
+				return "", err
+			}
+			if ts.hasXMP {
+				if v, ok := xmpValue(sl, "dc:description"); ok {
+					return v, nil
+				}
+			}
+			if ts.hasIPTC {
+				if v, ok := iptcValue(sl, iptcCaptionAbstract); ok {
+					return v, nil
+				}
+			}
+			return ts.exif, nil
+		},
+		// Distinct from "title": writes XMP dc:description and IPTC
+		// Caption-Abstract, mirroring the read path above, rather than
+		// EXIF ImageDescription (which "title" already owns). Unlike title,
+		// there's no EXIF fallback to use without re-colliding with title's
+		// tag, so a file with no IPTC block and no XMP dc:description
+		// element reports an error instead of silently dropping the value.
+		write: func(sl *jpegstructure.SegmentList, value string) error {
+			wroteXMP := setXMPField(sl, "dc:description", value)
+			wroteIPTC := setPhotoshopIPTCField(sl, iptcCaptionAbstract, value)
+			if !wroteXMP && !wroteIPTC {
+				return fmt.Errorf("no IPTC block or XMP dc:description element present to write description into")
+			}
+			return nil
+		},
+	},
+	"artist":    {read: exifStringReader("Artist"), write: exifStringField("Artist")},
+	"copyright": {read: exifStringReader("Copyright"), write: exifStringField("Copyright")},
+	"datetime":  {read: exifStringReader("DateTime"), write: exifStringField("DateTime")},
+	"gps":       {read: readGPS, write: writeGPS},
+	"rating":    {read: readXMPRating, write: writeXMPRating},
+	"keywords":  {read: readXMPKeywords, write: writeXMPKeywords},
+}
+
+// parseFields turns a --fields value ("title,artist,gps") into an ordered,
+// validated list of field keys.
+func parseFields(raw string) ([]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return defaultFieldNames, nil
+	}
+	var fields []string
+	for _, part := range strings.Split(raw, ",") {
+		key := strings.TrimSpace(part)
+		if key == "" {
+			continue
+		}
+		if _, ok := fieldSpecs[key]; !ok {
+			return nil, fmt.Errorf("unknown field %q", key)
+		}
+		fields = append(fields, key)
+	}
+	if len(fields) == 0 {
+		return defaultFieldNames, nil
+	}
+	return fields, nil
+}
+
+func exifStringReader(tagName string) func(sl *jpegstructure.SegmentList) (string, error) {
+	return func(sl *jpegstructure.SegmentList) (string, error) {
+		rootIfd, _, err := sl.Exif()
+		if err != nil {
+			return "", nil
+		}
+		return exifTagString(rootIfd, tagName), nil
+	}
+}
+
+func exifTagString(rootIfd *exif.Ifd, tagName string) string {
+	results, err := rootIfd.FindTagWithName(tagName)
+	if err != nil || len(results) == 0 {
+		return ""
+	}
+	value, err := results[0].Value()
+	if err != nil {
+		return ""
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case []string:
+		if len(v) > 0 {
+			return v[0]
+		}
+	case []byte:
+		return string(v)
+	case [][]byte:
+		if len(v) > 0 {
+			return string(v[0])
+		}
+	}
+	return ""
+}
+
+func exifStringField(tagName string) func(sl *jpegstructure.SegmentList, value string) error {
+	return func(sl *jpegstructure.SegmentList, value string) error {
+		rootIb, err := sl.ConstructExifBuilder()
+		if err != nil {
+			return fmt.Errorf("build EXIF: %w", err)
+		}
+		if err := rootIb.SetStandardWithName(tagName, value); err != nil {
+			return fmt.Errorf("set %s: %w", tagName, err)
+		}
+		if err := sl.SetExif(rootIb); err != nil {
+			return fmt.Errorf("write EXIF to JPEG structure: %w", err)
+		}
+		return nil
+	}
+}
+
+// --- GPS, stored as "lat,lon" decimal degrees (e.g. "59.334591,18.063240") ---
+
+func readGPS(sl *jpegstructure.SegmentList) (string, error) {
+	rootIfd, _, err := sl.Exif()
+	if err != nil {
+		return "", nil
+	}
+	gpsIfd, err := rootIfd.ChildWithIfdPath(exifcommon.IfdGpsInfoStandardIfdIdentity)
+	if err != nil {
+		return "", nil
+	}
+
+	lat, err := gpsDecimalDegrees(gpsIfd, "GPSLatitude", "GPSLatitudeRef", "S")
+	if err != nil {
+		return "", nil
+	}
+	lon, err := gpsDecimalDegrees(gpsIfd, "GPSLongitude", "GPSLongitudeRef", "W")
+	if err != nil {
+		return "", nil
+	}
+	return fmt.Sprintf("%.6f,%.6f", lat, lon), nil
+}
+
+func gpsDecimalDegrees(gpsIfd *exif.Ifd, tagName, refTagName, negativeRef string) (float64, error) {
+	results, err := gpsIfd.FindTagWithName(tagName)
+	if err != nil || len(results) == 0 {
+		return 0, fmt.Errorf("%s not present", tagName)
+	}
+	raw, err := results[0].Value()
+	if err != nil {
+		return 0, err
+	}
+	rationals, ok := raw.([]exifcommon.Rational)
+	if !ok || len(rationals) != 3 {
+		return 0, fmt.Errorf("%s has unexpected shape", tagName)
+	}
+
+	degrees := rationalToFloat(rationals[0])
+	minutes := rationalToFloat(rationals[1])
+	seconds := rationalToFloat(rationals[2])
+
+	ref := ""
+	if refResults, err := gpsIfd.FindTagWithName(refTagName); err == nil && len(refResults) > 0 {
+		if v, err := refResults[0].Value(); err == nil {
+			ref = fmt.Sprintf("%s", v)
+		}
+	}
+	return dmsToDecimal(degrees, minutes, seconds, ref, negativeRef), nil
+}
+
+// decimalToDMS splits decimal degrees into degrees/minutes/seconds plus a
+// hemisphere reference letter, the shape EXIF's GPS tags use (the degree
+// tags are always non-negative; sign lives in the Ref tag instead).
+func decimalToDMS(decimal float64, positiveRef, negativeRef string) (degrees, minutes, seconds float64, ref string) {
+	ref = positiveRef
+	if decimal < 0 {
+		ref = negativeRef
+		decimal = -decimal
+	}
+	degrees = math.Trunc(decimal)
+	minutesFull := (decimal - degrees) * 60
+	minutes = math.Trunc(minutesFull)
+	seconds = (minutesFull - minutes) * 60
+	return degrees, minutes, seconds, ref
+}
+
+// dmsToDecimal is decimalToDMS's inverse.
+func dmsToDecimal(degrees, minutes, seconds float64, ref, negativeRef string) float64 {
+	decimal := degrees + minutes/60 + seconds/3600
+	if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(ref)), negativeRef) {
+		decimal = -decimal
+	}
+	return decimal
+}
+
+func rationalToFloat(r exifcommon.Rational) float64 {
+	if r.Denominator == 0 {
+		return 0
+	}
+	return float64(r.Numerator) / float64(r.Denominator)
+}
+
+func writeGPS(sl *jpegstructure.SegmentList, value string) error {
+	lat, lon, err := parseLatLon(value)
+	if err != nil {
+		return err
+	}
+
+	rootIb, err := sl.ConstructExifBuilder()
+	if err != nil {
+		return fmt.Errorf("build EXIF: %w", err)
+	}
+
+	// Reuse the existing GPS child IFD when ConstructExifBuilder already
+	// carried one over from the source file; otherwise AddChildIb would
+	// duplicate it instead of updating the coordinates in place.
+	gpsIb, err := rootIb.ChildWithIfdPath(exifcommon.IfdGpsInfoStandardIfdIdentity)
+	if err != nil {
+		im, err := exifcommon.NewIfdMappingWithStandard()
+		if err != nil {
+			return fmt.Errorf("build IFD mapping: %w", err)
+		}
+		ti := exif.NewTagIndex()
+		// The GPS child IFD must share rootIb's byte order, or AddChildIb
+		// rejects it; fall back to the default only for a file with no
+		// existing EXIF to inherit one from.
+		byteOrder := exifcommon.EncodeDefaultByteOrder
+		if rootIfd, _, err := sl.Exif(); err == nil && rootIfd != nil {
+			byteOrder = rootIfd.ByteOrder()
+		}
+		gpsIb = exif.NewIfdBuilder(im, ti, exifcommon.IfdGpsInfoStandardIfdIdentity, byteOrder)
+		if err := rootIb.AddChildIb(gpsIb); err != nil {
+			return fmt.Errorf("add GPS IFD: %w", err)
+		}
+	}
+
+	if err := setGPSCoordinate(gpsIb, "GPSLatitude", "GPSLatitudeRef", lat, "N", "S"); err != nil {
+		return err
+	}
+	if err := setGPSCoordinate(gpsIb, "GPSLongitude", "GPSLongitudeRef", lon, "E", "W"); err != nil {
+		return err
+	}
+
+	if err := sl.SetExif(rootIb); err != nil {
+		return fmt.Errorf("write EXIF to JPEG structure: %w", err)
+	}
+	return nil
+}
+
+func setGPSCoordinate(gpsIb *exif.IfdBuilder, tagName, refTagName string, decimal float64, positiveRef, negativeRef string) error {
+	degrees, minutes, seconds, ref := decimalToDMS(decimal, positiveRef, negativeRef)
+
+	rationals := []exifcommon.Rational{
+		{Numerator: uint32(degrees), Denominator: 1},
+		{Numerator: uint32(minutes), Denominator: 1},
+		{Numerator: uint32(seconds * 1000), Denominator: 1000},
+	}
+	if err := gpsIb.SetStandardWithName(tagName, rationals); err != nil {
+		return fmt.Errorf("set %s: %w", tagName, err)
+	}
+	if err := gpsIb.SetStandardWithName(refTagName, ref); err != nil {
+		return fmt.Errorf("set %s: %w", refTagName, err)
+	}
+	return nil
+}
+
+func parseLatLon(value string) (lat, lon float64, err error) {
+	parts := strings.SplitN(value, ",", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("gps value %q must be \"lat,lon\"", value)
+	}
+	lat, err = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("parse latitude: %w", err)
+	}
+	lon, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("parse longitude: %w", err)
+	}
+	return lat, lon, nil
+}
+
+// --- Rating (XMP xmp:Rating attribute on rdf:Description) ---
+
+var xmpRatingRe = regexp.MustCompile(`xmp:Rating="(-?\d+)"`)
+
+func readXMPRating(sl *jpegstructure.SegmentList) (string, error) {
+	packet, ok := findXMPPacket(sl)
+	if !ok {
+		return "", nil
+	}
+	if m := xmpRatingRe.FindStringSubmatch(packet); m != nil {
+		return m[1], nil
+	}
+	return "", nil
+}
+
+func writeXMPRating(sl *jpegstructure.SegmentList, value string) error {
+	for _, seg := range sl.Segments() {
+		if seg.MarkerId != markerAPP1 || !hasXMPPrefix(seg.Data) {
+			continue
+		}
+		packet := string(seg.Data[len(xmpSignature):])
+		updated, ok := setXMPRating(packet, value)
+		if !ok {
+			continue
+		}
+		seg.Data = append([]byte(xmpSignature), []byte(updated)...)
+		return nil
+	}
+	return nil
+}
+
+func setXMPRating(packet, value string) (string, bool) {
+	if xmpRatingRe.MatchString(packet) {
+		return xmpRatingRe.ReplaceAllString(packet, `xmp:Rating="`+value+`"`), true
+	}
+	descRe := regexp.MustCompile(`<rdf:Description([^>]*?)(/?>)`)
+	if descRe.MatchString(packet) {
+		return descRe.ReplaceAllString(packet, `<rdf:Description$1 xmp:Rating="`+value+`"$2`), true
+	}
+	return packet, false
+}
+
+// --- Keywords (XMP dc:subject rdf:Bag of rdf:li entries, ";"-joined) ---
+
+var xmpSubjectBagRe = regexp.MustCompile(`(?s)<dc:subject>.*?<rdf:Bag>(.*?)</rdf:Bag>.*?</dc:subject>`)
+var xmpLiRe = regexp.MustCompile(`(?s)<rdf:li[^>]*>(.*?)</rdf:li>`)
+
+func readXMPKeywords(sl *jpegstructure.SegmentList) (string, error) {
+	packet, ok := findXMPPacket(sl)
+	if !ok {
+		return "", nil
+	}
+	m := xmpSubjectBagRe.FindStringSubmatch(packet)
+	if m == nil {
+		return "", nil
+	}
+	var values []string
+	for _, li := range xmpLiRe.FindAllStringSubmatch(m[1], -1) {
+		values = append(values, strings.TrimSpace(li[1]))
+	}
+	return strings.Join(values, ";"), nil
+}
+
+func writeXMPKeywords(sl *jpegstructure.SegmentList, value string) error {
+	for _, seg := range sl.Segments() {
+		if seg.MarkerId != markerAPP1 || !hasXMPPrefix(seg.Data) {
+			continue
+		}
+		packet := string(seg.Data[len(xmpSignature):])
+		if !xmpSubjectBagRe.MatchString(packet) {
+			continue
+		}
+		var items strings.Builder
+		if value != "" {
+			for _, kw := range strings.Split(value, ";") {
+				items.WriteString("<rdf:li>" + xmpEscape(strings.TrimSpace(kw)) + "</rdf:li>")
+			}
+		}
+		updated := xmpSubjectBagRe.ReplaceAllString(packet, "<dc:subject><rdf:Bag>"+items.String()+"</rdf:Bag></dc:subject>")
+		seg.Data = append([]byte(xmpSignature), []byte(updated)...)
+		return nil
+	}
+	return nil
+}
+
+func hasXMPPrefix(data []byte) bool {
+	return len(data) >= len(xmpSignature) && string(data[:len(xmpSignature)]) == xmpSignature
+}
+
+func xmpValue(sl *jpegstructure.SegmentList, tag string) (string, bool) {
+	packet, ok := findXMPPacket(sl)
+	if !ok {
+		return "", false
+	}
+	return xmpSimpleValue(packet, tag)
+}
+
+func iptcValue(sl *jpegstructure.SegmentList, key iptcKey) (string, bool) {
+	data, ok := findPhotoshopIPTC(sl)
+	if !ok {
+		return "", false
+	}
+	v, ok := parseIPTCDataSets(data)[key]
+	return v, ok
+}
+
+// scanColumns returns the CSV header for a scan over fields. "title"
+// expands into the merged column plus its three per-source diagnostic
+// columns, matching the original title-only CSV shape.
+func scanColumns(fields []string) []string {
+	cols := []string{"filename"}
+	for _, key := range fields {
+		cols = append(cols, key)
+		if key == "title" {
+			cols = append(cols, "title_exif", "title_iptc", "title_xmp")
+		}
+	}
+	return cols
+}
+
+func scanFileRow(path, csvName string, fields []string) ([]string, error) {
+	mp := jpegstructure.NewJpegMediaParser()
+	intfc, err := mp.ParseFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("parse JPEG: %w", err)
+	}
+	sl := intfc.(*jpegstructure.SegmentList)
+
+	row := []string{csvName}
+	for _, key := range fields {
+		if key == "title" {
+			ts, err := readTitleSetFromSegments(sl)
+			if err != nil {
+				return nil, err
+			}
+			row = append(row, mergeTitle(ts), ts.exif, ts.iptc, ts.xmp)
+			continue
+		}
+		value, err := fieldSpecs[key].read(sl)
+		if err != nil {
+			return nil, err
+		}
+		row = append(row, value)
+	}
+	return row, nil
+}
+
+// fieldWriteOrder fixes the order upsertFields applies values in, since
+// values is a map and iteration order would otherwise be nondeterministic.
+var fieldWriteOrder = []string{"artist", "copyright", "datetime", "gps", "rating", "keywords", "title", "description"}
+
+// upsertFields writes every field present in values into path's
+// metadata in one parse/rewrite pass, in fieldWriteOrder.
+func upsertFields(path string, values map[string]string, opts writeOptions) error {
+	mp := jpegstructure.NewJpegMediaParser()
+	intfc, err := mp.ParseFile(path)
+	if err != nil {
+		return fmt.Errorf("parse JPEG: %w", err)
+	}
+	sl := intfc.(*jpegstructure.SegmentList)
+
+	for _, key := range fieldWriteOrder {
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		if err := fieldSpecs[key].write(sl, value); err != nil {
+			return fmt.Errorf("set %s: %w", key, err)
+		}
+	}
+
+	return commitRewrite(sl, path, opts)
+}
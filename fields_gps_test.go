@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestDecimalDMSRoundTrip(t *testing.T) {
+	cases := []struct {
+		name     string
+		decimal  float64
+		positive string
+		negative string
+	}{
+		{"stockholm latitude", 59.334591, "N", "S"},
+		{"stockholm longitude", 18.063240, "E", "W"},
+		{"southern latitude", -33.865143, "N", "S"},
+		{"western longitude", -151.209900, "E", "W"},
+		{"zero", 0, "N", "S"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			degrees, minutes, seconds, ref := decimalToDMS(c.decimal, c.positive, c.negative)
+			got := dmsToDecimal(degrees, minutes, seconds, ref, c.negative)
+			if math.Abs(got-c.decimal) > 1e-6 {
+				t.Errorf("round-trip: got %f, want %f", got, c.decimal)
+			}
+		})
+	}
+}
+
+// TestGPSFieldRoundTrip exercises the same degree<->DMS conversion that
+// writeGPS/readGPS perform on either side of an EXIF GPS write, using
+// parseLatLon's "lat,lon" format to confirm the whole --fields=gps value
+// survives a write/read cycle. It stops short of a real JPEG fixture: the
+// ConstructExifBuilder/AddChildIb plumbing has no alternative but the
+// dsoprea library itself, which this tree can't build (no go.mod/vendored
+// deps in this sandbox).
+func TestGPSFieldRoundTrip(t *testing.T) {
+	const value = "59.334591,-18.063240"
+	lat, lon, err := parseLatLon(value)
+	if err != nil {
+		t.Fatalf("parseLatLon: %v", err)
+	}
+
+	latDeg, latMin, latSec, latRef := decimalToDMS(lat, "N", "S")
+	lonDeg, lonMin, lonSec, lonRef := decimalToDMS(lon, "E", "W")
+
+	gotLat := dmsToDecimal(latDeg, latMin, latSec, latRef, "S")
+	gotLon := dmsToDecimal(lonDeg, lonMin, lonSec, lonRef, "W")
+
+	got := fmt.Sprintf("%.6f,%.6f", gotLat, gotLon)
+	if got != value {
+		t.Errorf("gps round-trip: got %q, want %q", got, value)
+	}
+}
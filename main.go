@@ -8,9 +8,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
-
-	jpegstructure "github.com/dsoprea/go-jpeg-image-structure/v2"
 )
 
 func main() {
@@ -18,16 +17,27 @@ func main() {
 	scanShort := flag.Bool("s", false, "Alias for --scan")
 	applyLong := flag.Bool("apply", false, "Apply titles from bilder.csv in a directory")
 	applyShort := flag.Bool("a", false, "Alias for --apply")
+	verifyLong := flag.Bool("verify", false, "Diff bilder.csv against on-disk titles without writing")
+	verifyShort := flag.Bool("v", false, "Alias for --verify")
+	jobs := flag.Int("jobs", runtime.NumCPU(), "Number of files to process concurrently")
+	progress := flag.Bool("progress", false, "Print processed/total progress to stderr")
+	touch := flag.Bool("touch", false, "Let --apply update the file's mtime instead of preserving it")
+	minimalRewrite := flag.Bool("minimal-rewrite", false, "Copy JPEG segments byte-for-byte, only replacing the APP1/Exif, APP1/XMP and APP13/IPTC segments")
+	recursiveLong := flag.Bool("recursive", false, "Walk subdirectories")
+	recursiveShort := flag.Bool("r", false, "Alias for --recursive")
+	csvMode := flag.String("csv-mode", csvModePerDir, `With --recursive, CSV layout: "per-dir" (one bilder.csv per directory) or "root" (one bilder.csv at the walk root)`)
+	fieldsFlag := flag.String("fields", "", "Comma-separated metadata fields to read/write (default: title). One of: title, description, artist, copyright, datetime, gps, rating, keywords")
 
 	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n  %s --scan <dir>\n  %s --apply <dir>\n\n", os.Args[0], os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n  %s --scan [-r] <dir>\n  %s --apply [-r] <dir>\n  %s --verify <dir>\n\n", os.Args[0], os.Args[0], os.Args[0])
 		flag.PrintDefaults()
 	}
 	flag.Parse()
 
 	doScan := *scanLong || *scanShort
 	doApply := *applyLong || *applyShort
-	if doScan == doApply {
+	doVerify := *verifyLong || *verifyShort
+	if numTrue(doScan, doApply, doVerify) != 1 {
 		flag.Usage()
 		os.Exit(2)
 	}
@@ -38,20 +48,51 @@ func main() {
 		os.Exit(2)
 	}
 
-	var err error
-	if doScan {
-		err = scanDirectory(dir)
-	} else {
-		err = applyTitlesFromCSV(dir)
+	recursive := *recursiveLong || *recursiveShort
+	fields, err := parseFields(*fieldsFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch {
+	case doScan:
+		if recursive {
+			err = scanRecursive(dir, *jobs, *progress, *csvMode, fields)
+		} else {
+			err = scanDirectory(dir, *jobs, *progress, fields)
+		}
+	case doApply:
+		opts := writeOptions{touch: *touch, minimalRewrite: *minimalRewrite}
+		if recursive {
+			err = applyRecursive(dir, *jobs, *progress, opts)
+		} else {
+			err = applyTitlesFromCSV(dir, *jobs, *progress, opts)
+		}
+	case doVerify:
+		var driftFound bool
+		driftFound, err = verifyDirectory(os.Stdout, dir)
+		if err == nil && driftFound {
+			os.Exit(1)
+		}
 	}
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
+func numTrue(values ...bool) int {
+	n := 0
+	for _, v := range values {
+		if v {
+			n++
+		}
+	}
+	return n
+}
+
 const csvFilename = "bilder.csv"
 
-func scanDirectory(dir string) error {
+func scanDirectory(dir string, jobs int, showProgress bool, fields []string) error {
 	dir = filepath.Clean(dir)
 
 	entries, err := os.ReadDir(dir)
@@ -59,23 +100,12 @@ func scanDirectory(dir string) error {
 		return fmt.Errorf("read directory: %w", err)
 	}
 
-	csvPath := filepath.Join(dir, csvFilename)
-	file, err := os.Create(csvPath)
-	if err != nil {
-		return fmt.Errorf("create csv: %w", err)
-	}
-	defer file.Close()
-
-	writer := newCSVWriter(file)
-	if err := writer.Write([]string{"filename", "title"}); err != nil {
-		return fmt.Errorf("write header: %w", err)
-	}
-
 	absDir, err := filepath.Abs(dir)
 	if err != nil {
 		return err
 	}
 
+	var files []scanFile
 	for _, entry := range entries {
 		if entry.IsDir() || !entry.Type().IsRegular() {
 			continue
@@ -84,16 +114,56 @@ func scanDirectory(dir string) error {
 		if strings.EqualFold(name, csvFilename) || !isJPEG(name) {
 			continue
 		}
+		files = append(files, scanFile{absPath: filepath.Join(absDir, name), csvName: name})
+	}
 
-		title, err := readTitle(filepath.Join(absDir, entry.Name()))
+	return scanFileList(filepath.Join(dir, csvFilename), files, jobs, showProgress, fields)
+}
+
+// scanFile pairs a file to read metadata from with the name it should be
+// recorded under in bilder.csv: a bare filename for a single-directory
+// scan, or a root-relative path for --csv-mode=root.
+type scanFile struct {
+	absPath string
+	csvName string
+}
+
+// scanFileList is the shared engine behind a plain scanDirectory and a
+// --recursive --csv-mode=root scan: both just need to decide which files
+// go in and what to call them in the CSV. fields selects which columns
+// (beyond filename) to populate, per the fieldSpecs table.
+func scanFileList(csvPath string, files []scanFile, jobs int, showProgress bool, fields []string) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	rows := make([][]string, len(files))
+	err := runWorkerPool(len(files), jobs, showProgress, func(i int) error {
+		row, err := scanFileRow(files[i].absPath, files[i].csvName, fields)
 		if err != nil {
 			return err
 		}
+		rows[i] = row
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		if err := writer.Write([]string{name, title}); err != nil {
-			return fmt.Errorf("write row for %s: %w", name, err)
-		}
+	file, err := os.Create(csvPath)
+	if err != nil {
+		return fmt.Errorf("create csv: %w", err)
+	}
+	defer file.Close()
 
+	writer := newCSVWriter(file)
+	if err := writer.Write(scanColumns(fields)); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	for _, row := range rows {
+		if err := writer.Write(row); err != nil {
+			return fmt.Errorf("write row for %s: %w", row[0], err)
+		}
 	}
 	if err := writer.Flush(); err != nil {
 		return fmt.Errorf("flush csv: %w", err)
@@ -102,7 +172,7 @@ func scanDirectory(dir string) error {
 	return nil
 }
 
-func applyTitlesFromCSV(dir string) error {
+func applyTitlesFromCSV(dir string, jobs int, showProgress bool, opts writeOptions) error {
 	dir = filepath.Clean(dir)
 
 	csvPath := filepath.Join(dir, csvFilename)
@@ -117,11 +187,34 @@ func applyTitlesFromCSV(dir string) error {
 	if err != nil {
 		return fmt.Errorf("read header: %w", err)
 	}
-	filenameIdx, titleIdx := headerIndex(header, "filename"), headerIndex(header, "title")
-	if filenameIdx == -1 || titleIdx == -1 {
-		return fmt.Errorf("csv must include filename and title columns")
+	filenameIdx := headerIndex(header, "filename")
+	if filenameIdx == -1 {
+		return fmt.Errorf("csv must include a filename column")
 	}
 
+	// Only header columns that map to a known field are written back;
+	// diagnostic columns like title_exif are read-only.
+	type column struct {
+		index int
+		key   string
+	}
+	var columns []column
+	for i, name := range header {
+		key := strings.TrimSpace(name)
+		if _, ok := fieldSpecs[key]; ok {
+			columns = append(columns, column{index: i, key: key})
+		}
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("csv has no columns matching a known field")
+	}
+
+	type applyJob struct {
+		filename string
+		path     string
+		values   map[string]string
+	}
+	var jobList []applyJob
 	for {
 		record, err := reader.Read()
 		if err == io.EOF {
@@ -137,21 +230,32 @@ func applyTitlesFromCSV(dir string) error {
 		if filename == "" {
 			continue
 		}
-		title := ""
-		if titleIdx < len(record) {
-			title = record[titleIdx]
+
+		values := make(map[string]string, len(columns))
+		for _, col := range columns {
+			if col.index < len(record) {
+				values[col.key] = record[col.index]
+			}
 		}
 
 		path := filename
 		if !filepath.IsAbs(path) {
 			path = filepath.Join(dir, path)
 		}
-		if err := upsertTitle(path, title); err != nil {
-			return fmt.Errorf("apply title for %s: %w", filename, err)
-		}
+		jobList = append(jobList, applyJob{filename: filename, path: path, values: values})
 	}
 
-	return nil
+	locks := newKeyedMutex()
+	return runWorkerPool(len(jobList), jobs, showProgress, func(i int) error {
+		job := jobList[i]
+		unlock := locks.Lock(job.path)
+		defer unlock()
+
+		if err := upsertFields(job.path, job.values, opts); err != nil {
+			return fmt.Errorf("apply fields for %s: %w", job.filename, err)
+		}
+		return nil
+	})
 }
 
 func headerIndex(header []string, name string) int {
@@ -271,84 +375,3 @@ func parseCSVLine(line string) ([]string, error) {
 	fields = append(fields, field.String())
 	return fields, nil
 }
-
-func readTitle(path string) (string, error) {
-	mp := jpegstructure.NewJpegMediaParser()
-	intfc, err := mp.ParseFile(path)
-	if err != nil {
-		return "", fmt.Errorf("parse JPEG: %w", err)
-	}
-
-	sl := intfc.(*jpegstructure.SegmentList)
-
-	rootIfd, _, err := sl.Exif()
-	if err != nil {
-		return "", fmt.Errorf("parse EXIF: %w", err)
-	}
-
-	results, err := rootIfd.FindTagWithName("ImageDescription")
-	if err != nil {
-		// Tag not found or other error
-		return "", nil
-	}
-
-	if len(results) == 0 {
-		return "", nil
-	}
-
-	value, err := results[0].Value()
-	if err != nil {
-		return "", nil
-	}
-	switch title := value.(type) {
-	case string:
-		return title, nil
-	case []string:
-		if len(title) > 0 {
-			return title[0], nil
-		}
-	case []byte:
-		return string(title), nil
-	case [][]byte:
-		if len(title) > 0 {
-			return string(title[0]), nil
-		}
-	}
-
-	return "", nil
-}
-
-func upsertTitle(path, title string) error {
-	mp := jpegstructure.NewJpegMediaParser()
-	intfc, err := mp.ParseFile(path)
-	if err != nil {
-		return fmt.Errorf("parse JPEG: %w", err)
-	}
-
-	sl := intfc.(*jpegstructure.SegmentList)
-
-	rootIb, err := sl.ConstructExifBuilder()
-	if err != nil {
-		return fmt.Errorf("build EXIF: %w", err)
-	}
-
-	if err := rootIb.SetStandardWithName("ImageDescription", title); err != nil {
-		return fmt.Errorf("set title: %w", err)
-	}
-
-	if err := sl.SetExif(rootIb); err != nil {
-		return fmt.Errorf("write EXIF to JPEG structure: %w", err)
-	}
-
-	f, err := os.Create(path)
-	if err != nil {
-		return fmt.Errorf("open for write: %w", err)
-	}
-	defer f.Close()
-
-	if err := sl.Write(f); err != nil {
-		return fmt.Errorf("write JPEG: %w", err)
-	}
-
-	return nil
-}
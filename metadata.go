@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strings"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	jpegstructure "github.com/dsoprea/go-jpeg-image-structure/v2"
+)
+
+// JPEG segment markers used to locate the sidecar metadata blocks below.
+// EXIF lives in an APP1 segment too, but jpegstructure already exposes
+// that one through SegmentList.Exif().
+const (
+	markerAPP1  = 0xE1 // XMP packet
+	markerAPP13 = 0xED // Photoshop "Image Resource Block", carries IPTC
+)
+
+const (
+	xmpSignature       = "http://ns.adobe.com/xap/1.0/\x00"
+	photoshopSignature = "Photoshop 3.0\x00"
+	iptcResourceID     = 0x0404
+)
+
+type iptcKey struct {
+	record, dataset byte
+}
+
+var (
+	iptcObjectName      = iptcKey{2, 5}
+	iptcCaptionAbstract = iptcKey{2, 120}
+)
+
+// titleSet holds the title/description carried by each of the three
+// sidecar sources we understand, plus whether that source's block is
+// present at all (an empty string and "no block" are different things:
+// the latter means upsertAllTitles has nothing to update).
+type titleSet struct {
+	exif, iptc, xmp          string
+	hasEXIF, hasIPTC, hasXMP bool
+}
+
+// mergeTitle resolves disagreements between sources. XMP wins first since
+// it's the format modern editors (Lightroom, Darktable, digiKam) write to
+// last and most completely; IPTC is next as the longstanding wire-service
+// standard; EXIF ImageDescription is the fallback since many cameras only
+// ever populate that one.
+func mergeTitle(t titleSet) string {
+	if t.hasXMP && t.xmp != "" {
+		return t.xmp
+	}
+	if t.hasIPTC && t.iptc != "" {
+		return t.iptc
+	}
+	return t.exif
+}
+
+func readTitleSetFromSegments(sl *jpegstructure.SegmentList) (titleSet, error) {
+	var ts titleSet
+
+	if rootIfd, _, err := sl.Exif(); err == nil {
+		ts.hasEXIF = true
+		ts.exif = exifImageDescription(rootIfd)
+	}
+
+	if data, ok := findPhotoshopIPTC(sl); ok {
+		ts.hasIPTC = true
+		fields := parseIPTCDataSets(data)
+		if v, ok := fields[iptcObjectName]; ok {
+			ts.iptc = v
+		} else if v, ok := fields[iptcCaptionAbstract]; ok {
+			ts.iptc = v
+		}
+	}
+
+	if packet, ok := findXMPPacket(sl); ok {
+		ts.hasXMP = true
+		if v, ok := xmpSimpleValue(packet, "dc:title"); ok {
+			ts.xmp = v
+		} else if v, ok := xmpSimpleValue(packet, "dc:description"); ok {
+			ts.xmp = v
+		}
+	}
+
+	return ts, nil
+}
+
+func exifImageDescription(rootIfd *exif.Ifd) string {
+	results, err := rootIfd.FindTagWithName("ImageDescription")
+	if err != nil || len(results) == 0 {
+		return ""
+	}
+
+	value, err := results[0].Value()
+	if err != nil {
+		return ""
+	}
+	switch title := value.(type) {
+	case string:
+		return title
+	case []string:
+		if len(title) > 0 {
+			return title[0]
+		}
+	case []byte:
+		return string(title)
+	case [][]byte:
+		if len(title) > 0 {
+			return string(title[0])
+		}
+	}
+	return ""
+}
+
+// upsertAllTitles writes title into the EXIF ImageDescription tag (always,
+// matching the tool's original behavior) and additionally into the IPTC
+// ObjectName dataset when an IPTC block already exists in the file, and
+// into XMP dc:title when the XMP packet already has a dc:title element.
+// An XMP packet without dc:title (including one carrying only
+// dc:description) is left unchanged.
+func upsertAllTitles(sl *jpegstructure.SegmentList, title string) error {
+	rootIb, err := sl.ConstructExifBuilder()
+	if err != nil {
+		return fmt.Errorf("build EXIF: %w", err)
+	}
+	if err := rootIb.SetStandardWithName("ImageDescription", title); err != nil {
+		return fmt.Errorf("set title: %w", err)
+	}
+	if err := sl.SetExif(rootIb); err != nil {
+		return fmt.Errorf("write EXIF to JPEG structure: %w", err)
+	}
+
+	setPhotoshopIPTCField(sl, iptcObjectName, title)
+	setXMPField(sl, "dc:title", title)
+
+	return nil
+}
+
+// --- IPTC (Photoshop Image Resource Block, IIM datasets) ---
+
+func findPhotoshopIPTC(sl *jpegstructure.SegmentList) ([]byte, bool) {
+	for _, seg := range sl.Segments() {
+		if seg.MarkerId != markerAPP13 || !bytes.HasPrefix(seg.Data, []byte(photoshopSignature)) {
+			continue
+		}
+		if data, ok := findIRBResource(seg.Data[len(photoshopSignature):], iptcResourceID); ok {
+			return data, true
+		}
+	}
+	return nil, false
+}
+
+func setPhotoshopIPTCField(sl *jpegstructure.SegmentList, key iptcKey, value string) bool {
+	for _, seg := range sl.Segments() {
+		if seg.MarkerId != markerAPP13 || !bytes.HasPrefix(seg.Data, []byte(photoshopSignature)) {
+			continue
+		}
+		blocks := seg.Data[len(photoshopSignature):]
+		iptcData, ok := findIRBResource(blocks, iptcResourceID)
+		if !ok {
+			continue
+		}
+		newIPTC := setIPTCDataSet(iptcData, key, value)
+		newBlocks := replaceIRBResource(blocks, iptcResourceID, newIPTC)
+		seg.Data = append(append([]byte{}, []byte(photoshopSignature)...), newBlocks...)
+		return true
+	}
+	return false
+}
+
+// findIRBResource walks a Photoshop Image Resource Block looking for the
+// "8BIM" resource with the given ID (0x0404 is IPTC-NAA record).
+func findIRBResource(blocks []byte, resourceID uint16) ([]byte, bool) {
+	i := 0
+	for i+4 <= len(blocks) && string(blocks[i:i+4]) == "8BIM" {
+		i += 4
+		if i+2 > len(blocks) {
+			break
+		}
+		id := binary.BigEndian.Uint16(blocks[i : i+2])
+		i += 2
+
+		nameLen := int(blocks[i])
+		i++
+		i += nameLen
+		if nameLen%2 == 0 { // pascal string padded so size+name is even
+			i++
+		}
+		if i+4 > len(blocks) {
+			break
+		}
+		size := int(binary.BigEndian.Uint32(blocks[i : i+4]))
+		i += 4
+		if i+size > len(blocks) {
+			break
+		}
+		data := blocks[i : i+size]
+		i += size
+		if size%2 != 0 {
+			i++
+		}
+
+		if id == resourceID {
+			return data, true
+		}
+	}
+	return nil, false
+}
+
+// replaceIRBResource rebuilds blocks with the named resource's data
+// swapped out for newData, leaving every other resource untouched.
+func replaceIRBResource(blocks []byte, resourceID uint16, newData []byte) []byte {
+	var out bytes.Buffer
+	i := 0
+	for i+4 <= len(blocks) && string(blocks[i:i+4]) == "8BIM" {
+		start := i
+		i += 4
+		id := binary.BigEndian.Uint16(blocks[i : i+2])
+		i += 2
+		nameLen := int(blocks[i])
+		i++
+		i += nameLen
+		if nameLen%2 == 0 {
+			i++
+		}
+		size := int(binary.BigEndian.Uint32(blocks[i : i+4]))
+		i += 4
+		dataStart := i
+		i += size
+		if size%2 != 0 {
+			i++
+		}
+
+		if id != resourceID {
+			out.Write(blocks[start:i])
+			continue
+		}
+
+		out.Write(blocks[start:dataStart])
+		padded := newData
+		if len(padded)%2 != 0 {
+			padded = append(append([]byte{}, padded...), 0)
+		}
+		var sizeField [4]byte
+		binary.BigEndian.PutUint32(sizeField[:], uint32(len(newData)))
+		out.Truncate(out.Len() - 4)
+		out.Write(sizeField[:])
+		out.Write(padded)
+	}
+	return out.Bytes()
+}
+
+func parseIPTCDataSets(data []byte) map[iptcKey]string {
+	const tagMarker = 0x1C
+	out := map[iptcKey]string{}
+	i := 0
+	for i+5 <= len(data) {
+		if data[i] != tagMarker {
+			i++
+			continue
+		}
+		record, dataset := data[i+1], data[i+2]
+		length := int(binary.BigEndian.Uint16(data[i+3 : i+5]))
+		start := i + 5
+		end := start + length
+		if length&0x8000 != 0 || end > len(data) {
+			break
+		}
+		out[iptcKey{record, dataset}] = string(data[start:end])
+		i = end
+	}
+	return out
+}
+
+// setIPTCDataSet returns data with key's value replaced (or appended, if
+// key wasn't already present) while leaving every other dataset as-is.
+func setIPTCDataSet(data []byte, key iptcKey, value string) []byte {
+	const tagMarker = 0x1C
+	var out bytes.Buffer
+	i, replaced := 0, false
+	for i+5 <= len(data) {
+		if data[i] != tagMarker {
+			out.WriteByte(data[i])
+			i++
+			continue
+		}
+		record, dataset := data[i+1], data[i+2]
+		length := int(binary.BigEndian.Uint16(data[i+3 : i+5]))
+		end := i + 5 + length
+		if end > len(data) {
+			out.Write(data[i:])
+			i = len(data)
+			break
+		}
+		if record == key.record && dataset == key.dataset {
+			writeIPTCDataSet(&out, key, value)
+			replaced = true
+		} else {
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	if !replaced {
+		writeIPTCDataSet(&out, key, value)
+	}
+	return out.Bytes()
+}
+
+func writeIPTCDataSet(out *bytes.Buffer, key iptcKey, value string) {
+	out.WriteByte(0x1C)
+	out.WriteByte(key.record)
+	out.WriteByte(key.dataset)
+	var length [2]byte
+	binary.BigEndian.PutUint16(length[:], uint16(len(value)))
+	out.Write(length[:])
+	out.WriteString(value)
+}
+
+// --- XMP (APP1 RDF/XML packet) ---
+
+func findXMPPacket(sl *jpegstructure.SegmentList) (string, bool) {
+	for _, seg := range sl.Segments() {
+		if seg.MarkerId == markerAPP1 && bytes.HasPrefix(seg.Data, []byte(xmpSignature)) {
+			return string(seg.Data[len(xmpSignature):]), true
+		}
+	}
+	return "", false
+}
+
+func setXMPField(sl *jpegstructure.SegmentList, tag, value string) bool {
+	for _, seg := range sl.Segments() {
+		if seg.MarkerId != markerAPP1 || !bytes.HasPrefix(seg.Data, []byte(xmpSignature)) {
+			continue
+		}
+		packet := string(seg.Data[len(xmpSignature):])
+		updated, ok := setXMPSimpleValue(packet, tag, value)
+		if !ok {
+			continue
+		}
+		seg.Data = append([]byte(xmpSignature), []byte(updated)...)
+		return true
+	}
+	return false
+}
+
+// xmpSimpleValue reads tag's text content, preferring the rdf:Alt/rdf:li
+// language-alternative form Adobe tools write and falling back to a bare
+// element in case another tool minimized the packet.
+func xmpSimpleValue(packet, tag string) (string, bool) {
+	if m := xmpAltRegexp(tag).FindStringSubmatch(packet); m != nil {
+		return strings.TrimSpace(m[2]), true
+	}
+	if m := xmpPlainRegexp(tag).FindStringSubmatch(packet); m != nil {
+		return strings.TrimSpace(m[2]), true
+	}
+	return "", false
+}
+
+// setXMPSimpleValue replaces tag's text content in packet. It only
+// understands the two shapes xmpSimpleValue reads; if neither is present
+// it reports false and leaves the packet untouched rather than guessing
+// at how to inject a brand new RDF structure.
+func setXMPSimpleValue(packet, tag, value string) (string, bool) {
+	if alt := xmpAltRegexp(tag); alt.MatchString(packet) {
+		return alt.ReplaceAllString(packet, "${1}"+xmpEscape(value)+"${3}"), true
+	}
+	if plain := xmpPlainRegexp(tag); plain.MatchString(packet) {
+		return plain.ReplaceAllString(packet, "${1}"+xmpEscape(value)+"${3}"), true
+	}
+	return packet, false
+}
+
+// xmpAltRegexp and xmpPlainRegexp each capture three groups: the opening
+// markup, the value, and the closing markup, so a read can return group 2
+// and a write can substitute it via "${1}"+value+"${3}".
+func xmpAltRegexp(tag string) *regexp.Regexp {
+	q := regexp.QuoteMeta(tag)
+	return regexp.MustCompile(`(?s)(<` + q + `>.*?<rdf:li[^>]*>)(.*?)(</rdf:li>.*?</` + q + `>)`)
+}
+
+func xmpPlainRegexp(tag string) *regexp.Regexp {
+	q := regexp.QuoteMeta(tag)
+	return regexp.MustCompile(`(?s)(<` + q + `>)(.*?)(</` + q + `>)`)
+}
+
+func xmpEscape(value string) string {
+	replacer := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	return replacer.Replace(value)
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+const xmpAltPacket = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF><rdf:Description>` +
+	`<dc:title><rdf:Alt><rdf:li xml:lang="x-default">Sunset</rdf:li></rdf:Alt></dc:title>` +
+	`</rdf:Description></rdf:RDF></x:xmpmeta>`
+
+const xmpPlainPacket = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF><rdf:Description>` +
+	`<dc:description>Sunset</dc:description>` +
+	`</rdf:Description></rdf:RDF></x:xmpmeta>`
+
+func TestXMPSimpleValueReadsValueNotMarkup(t *testing.T) {
+	if v, ok := xmpSimpleValue(xmpAltPacket, "dc:title"); !ok || v != "Sunset" {
+		t.Errorf("rdf:Alt form: got (%q, %v), want (%q, true)", v, ok, "Sunset")
+	}
+	if v, ok := xmpSimpleValue(xmpPlainPacket, "dc:description"); !ok || v != "Sunset" {
+		t.Errorf("plain form: got (%q, %v), want (%q, true)", v, ok, "Sunset")
+	}
+}
+
+func TestXMPSimpleValueRoundTrip(t *testing.T) {
+	updated, ok := setXMPSimpleValue(xmpAltPacket, "dc:title", "Dawn")
+	if !ok {
+		t.Fatalf("setXMPSimpleValue: not applied")
+	}
+	if v, ok := xmpSimpleValue(updated, "dc:title"); !ok || v != "Dawn" {
+		t.Errorf("after write: got (%q, %v), want (%q, true)", v, ok, "Dawn")
+	}
+}
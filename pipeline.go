@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// keyedMutex hands out a *sync.Mutex per key, so callers can serialize
+// access to the same file without serializing access to different files.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: map[string]*sync.Mutex{}}
+}
+
+func (k *keyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	lock, ok := k.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		k.locks[key] = lock
+	}
+	k.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// runWorkerPool runs fn(i) for every i in [0, total) across workers
+// goroutines and reports progress to stderr as each call finishes, if
+// showProgress is set. It returns the first error encountered, but lets
+// every in-flight call finish before returning.
+func runWorkerPool(total, workers int, showProgress bool, fn func(i int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	indexes := make(chan int)
+	go func() {
+		defer close(indexes)
+		for i := 0; i < total; i++ {
+			indexes <- i
+		}
+	}()
+
+	type outcome struct{ err error }
+	results := make(chan outcome)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results <- outcome{err: fn(i)}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var firstErr error
+	processed := 0
+	for res := range results {
+		processed++
+		if showProgress {
+			fmt.Fprintf(os.Stderr, "%d/%d\n", processed, total)
+		}
+		if res.err != nil && firstErr == nil {
+			firstErr = res.err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	jpegstructure "github.com/dsoprea/go-jpeg-image-structure/v2"
+)
+
+// writeOptions controls how commitRewrite commits a rewritten JPEG to disk.
+type writeOptions struct {
+	// touch lets the file's mtime advance to "now" instead of being
+	// restored to its original value.
+	touch bool
+	// minimalRewrite copies every segment except the APP1/Exif, APP1/XMP
+	// and APP13/IPTC ones byte-for-byte from the source file instead of
+	// letting jpegstructure re-serialize the whole SegmentList, so ICC
+	// profiles, MPF data and other segments this tool doesn't understand
+	// survive untouched. The three metadata segments are taken from the
+	// edited SegmentList.
+	minimalRewrite bool
+}
+
+// commitRewrite serializes sl back to path. It always writes to a temp
+// file in the same directory and renames it into place, so a crash or
+// power loss mid-write can't leave a corrupt image where the original
+// used to be.
+func commitRewrite(sl *jpegstructure.SegmentList, path string, opts writeOptions) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("stat: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // no-op once the rename below succeeds
+
+	if opts.minimalRewrite {
+		err = writeMinimalRewrite(tmp, path, sl)
+	} else {
+		err = sl.Write(tmp)
+	}
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("write JPEG: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+
+	if err := os.Chmod(tmpPath, info.Mode()); err != nil {
+		return fmt.Errorf("preserve file mode: %w", err)
+	}
+	if !opts.touch {
+		if err := os.Chtimes(tmpPath, info.ModTime(), info.ModTime()); err != nil {
+			return fmt.Errorf("preserve mtime: %w", err)
+		}
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("rename into place: %w", err)
+	}
+	return nil
+}
+
+func writeMinimalRewrite(dst io.Writer, srcPath string, sl *jpegstructure.SegmentList) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("open source: %w", err)
+	}
+	defer src.Close()
+
+	newExif, _ := findEXIFSegmentData(sl)
+	newXMP, _ := findXMPSegmentData(sl)
+	newIPTC, _ := findIPTCSegmentData(sl)
+	return copyJPEGMinimal(bufio.NewReader(src), dst, newExif, newXMP, newIPTC)
+}
+
+func findEXIFSegmentData(sl *jpegstructure.SegmentList) ([]byte, bool) {
+	for _, seg := range sl.Segments() {
+		if seg.MarkerId == markerAPP1 && bytes.HasPrefix(seg.Data, []byte(exifSignature)) {
+			return seg.Data, true
+		}
+	}
+	return nil, false
+}
+
+// findXMPSegmentData and findIPTCSegmentData mirror findEXIFSegmentData for
+// the other two sidecar blocks copyJPEGMinimal can substitute, so edits made
+// to sl's in-memory XMP/IPTC segments (title/description writes, --fields
+// rating/keywords) aren't silently dropped in --minimal-rewrite mode.
+func findXMPSegmentData(sl *jpegstructure.SegmentList) ([]byte, bool) {
+	for _, seg := range sl.Segments() {
+		if seg.MarkerId == markerAPP1 && bytes.HasPrefix(seg.Data, []byte(xmpSignature)) {
+			return seg.Data, true
+		}
+	}
+	return nil, false
+}
+
+func findIPTCSegmentData(sl *jpegstructure.SegmentList) ([]byte, bool) {
+	for _, seg := range sl.Segments() {
+		if seg.MarkerId == markerAPP13 && bytes.HasPrefix(seg.Data, []byte(photoshopSignature)) {
+			return seg.Data, true
+		}
+	}
+	return nil, false
+}
+
+const exifSignature = "Exif\x00\x00"
+
+const (
+	markerSOI = 0xD8
+	markerSOS = 0xDA
+	markerEOI = 0xD9
+)
+
+// standaloneMarker reports whether marker carries no length-prefixed
+// payload (TEM, the RST0-RST7 restart markers, SOI and EOI).
+func standaloneMarker(marker byte) bool {
+	return marker == 0x01 || (marker >= 0xD0 && marker <= markerEOI)
+}
+
+// copyJPEGMinimal streams src to dst, passing every segment through
+// byte-for-byte except the APP1/Exif, APP1/XMP and APP13/Photoshop(IPTC)
+// segments, which are replaced with newExif/newXMP/newIPTC respectively
+// (or dropped if the corresponding new value is nil). Once it reaches SOS
+// it copies the remainder of the file verbatim: the entropy-coded scan
+// data that follows has no declared length, so re-encoding it is neither
+// necessary nor safe.
+func copyJPEGMinimal(src *bufio.Reader, dst io.Writer, newExif, newXMP, newIPTC []byte) error {
+	marker, err := readMarker(src)
+	if err != nil {
+		return err
+	}
+	if marker != markerSOI {
+		return fmt.Errorf("not a JPEG: expected SOI, got %#x", marker)
+	}
+	if err := writeMarker(dst, markerSOI); err != nil {
+		return err
+	}
+
+	exifWritten, xmpWritten, iptcWritten := false, false, false
+	for {
+		marker, err := readMarker(src)
+		if err != nil {
+			return err
+		}
+
+		if marker == markerSOS {
+			if !exifWritten && newExif != nil {
+				if err := writeSegment(dst, markerAPP1, newExif); err != nil {
+					return err
+				}
+			}
+			if !xmpWritten && newXMP != nil {
+				if err := writeSegment(dst, markerAPP1, newXMP); err != nil {
+					return err
+				}
+			}
+			if !iptcWritten && newIPTC != nil {
+				if err := writeSegment(dst, markerAPP13, newIPTC); err != nil {
+					return err
+				}
+			}
+			if err := writeMarker(dst, markerSOS); err != nil {
+				return err
+			}
+			_, err := io.Copy(dst, src)
+			return err
+		}
+
+		if standaloneMarker(marker) {
+			if err := writeMarker(dst, marker); err != nil {
+				return err
+			}
+			continue
+		}
+
+		length, err := readUint16(src)
+		if err != nil {
+			return err
+		}
+		payload := make([]byte, length-2)
+		if _, err := io.ReadFull(src, payload); err != nil {
+			return err
+		}
+
+		if marker == markerAPP1 && bytes.HasPrefix(payload, []byte(exifSignature)) {
+			exifWritten = true
+			if newExif == nil {
+				continue
+			}
+			if err := writeSegment(dst, markerAPP1, newExif); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if marker == markerAPP1 && bytes.HasPrefix(payload, []byte(xmpSignature)) {
+			xmpWritten = true
+			if newXMP == nil {
+				continue
+			}
+			if err := writeSegment(dst, markerAPP1, newXMP); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if marker == markerAPP13 && bytes.HasPrefix(payload, []byte(photoshopSignature)) {
+			iptcWritten = true
+			if newIPTC == nil {
+				continue
+			}
+			if err := writeSegment(dst, markerAPP13, newIPTC); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := writeMarker(dst, marker); err != nil {
+			return err
+		}
+		if err := writeUint16(dst, length); err != nil {
+			return err
+		}
+		if _, err := dst.Write(payload); err != nil {
+			return err
+		}
+	}
+}
+
+func readMarker(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("read marker prefix: %w", err)
+	}
+	if b != 0xFF {
+		return 0, fmt.Errorf("expected marker prefix 0xFF, got %#x", b)
+	}
+	for {
+		marker, err := r.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("read marker: %w", err)
+		}
+		if marker != 0xFF { // skip fill bytes before the real marker
+			return marker, nil
+		}
+	}
+}
+
+func writeMarker(w io.Writer, marker byte) error {
+	_, err := w.Write([]byte{0xFF, marker})
+	return err
+}
+
+func readUint16(r io.Reader) (int, error) {
+	var buf [2]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+	return int(buf[0])<<8 | int(buf[1]), nil
+}
+
+func writeUint16(w io.Writer, value int) error {
+	_, err := w.Write([]byte{byte(value >> 8), byte(value)})
+	return err
+}
+
+func writeSegment(w io.Writer, marker byte, payload []byte) error {
+	if err := writeMarker(w, marker); err != nil {
+		return err
+	}
+	if err := writeUint16(w, len(payload)+2); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	jpegstructure "github.com/dsoprea/go-jpeg-image-structure/v2"
+)
+
+// verifyDirectory reads bilder.csv in dir and compares its field columns
+// against what's actually stored in each referenced JPEG, without writing
+// anything. It's meant for git pre-commit hooks or CI where bilder.csv is
+// checked into version control and hand-edited by multiple contributors.
+// Any header column matching a fieldSpecs key is checked, the same way
+// applyTitlesFromCSV picks which columns to write; diagnostic columns
+// like title_exif are ignored.
+//
+// It prints a unified-diff-style report of every mismatch to w, plus any
+// JPEG present on disk but missing from the CSV and any CSV row whose
+// file is missing on disk, and reports whether any drift was found.
+func verifyDirectory(w io.Writer, dir string) (driftFound bool, err error) {
+	dir = filepath.Clean(dir)
+
+	csvPath := filepath.Join(dir, csvFilename)
+	file, err := os.Open(csvPath)
+	if err != nil {
+		return false, fmt.Errorf("open csv: %w", err)
+	}
+	defer file.Close()
+
+	reader := newCSVReader(file)
+	header, err := reader.Read()
+	if err != nil {
+		return false, fmt.Errorf("read header: %w", err)
+	}
+	filenameIdx := headerIndex(header, "filename")
+	if filenameIdx == -1 {
+		return false, fmt.Errorf("csv must include a filename column")
+	}
+
+	type column struct {
+		index int
+		key   string
+	}
+	var columns []column
+	for i, name := range header {
+		key := strings.TrimSpace(name)
+		if _, ok := fieldSpecs[key]; ok {
+			columns = append(columns, column{index: i, key: key})
+		}
+	}
+	if len(columns) == 0 {
+		return false, fmt.Errorf("csv has no columns matching a known field")
+	}
+
+	seen := map[string]bool{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, fmt.Errorf("read record: %w", err)
+		}
+		if filenameIdx >= len(record) {
+			continue
+		}
+		name := strings.TrimSpace(record[filenameIdx])
+		if name == "" {
+			continue
+		}
+		seen[name] = true
+
+		path := name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if _, statErr := os.Stat(path); os.IsNotExist(statErr) {
+			fmt.Fprintf(w, "missing on disk: %s (listed in %s)\n", name, csvFilename)
+			driftFound = true
+			continue
+		}
+
+		mp := jpegstructure.NewJpegMediaParser()
+		intfc, err := mp.ParseFile(path)
+		if err != nil {
+			return false, fmt.Errorf("parse %s: %w", name, err)
+		}
+		sl := intfc.(*jpegstructure.SegmentList)
+
+		for _, col := range columns {
+			csvValue := ""
+			if col.index < len(record) {
+				csvValue = record[col.index]
+			}
+
+			diskValue, err := fieldSpecs[col.key].read(sl)
+			if err != nil {
+				return false, fmt.Errorf("read %s: %w", name, err)
+			}
+
+			if diskValue != csvValue {
+				printFieldDiff(w, name, col.key, csvValue, diskValue)
+				driftFound = true
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, fmt.Errorf("read directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !entry.Type().IsRegular() {
+			continue
+		}
+		name := entry.Name()
+		if strings.EqualFold(name, csvFilename) || !isJPEG(name) || seen[name] {
+			continue
+		}
+		fmt.Fprintf(w, "missing from %s: %s\n", csvFilename, name)
+		driftFound = true
+	}
+
+	return driftFound, nil
+}
+
+func printFieldDiff(w io.Writer, name, field, csvValue, diskValue string) {
+	fmt.Fprintf(w, "--- %s (%s)\t%s\n", name, field, csvFilename)
+	fmt.Fprintf(w, "+++ %s (%s)\ton disk\n", name, field)
+	fmt.Fprintf(w, "@@ -1 +1 @@\n")
+	fmt.Fprintf(w, "-%s\n", csvValue)
+	fmt.Fprintf(w, "+%s\n", diskValue)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	csvModePerDir = "per-dir"
+	csvModeRoot   = "root"
+)
+
+// scanRecursive walks root and scans every directory it finds, laid out
+// per --csv-mode: "per-dir" writes one bilder.csv per directory (the
+// default, unchanged single-directory behavior applied recursively),
+// "root" writes a single bilder.csv at root whose filename column holds
+// paths relative to root.
+func scanRecursive(root string, jobs int, showProgress bool, csvMode string, fields []string) error {
+	switch csvMode {
+	case csvModePerDir:
+		return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			return scanDirectory(path, jobs, showProgress, fields)
+		})
+	case csvModeRoot:
+		return scanRecursiveRoot(root, jobs, showProgress, fields)
+	default:
+		return fmt.Errorf("unknown --csv-mode %q", csvMode)
+	}
+}
+
+func scanRecursiveRoot(root string, jobs int, showProgress bool, fields []string) error {
+	root = filepath.Clean(root)
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+
+	var files []scanFile
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		name := d.Name()
+		if strings.EqualFold(name, csvFilename) || !isJPEG(name) {
+			return nil
+		}
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(absRoot, absPath)
+		if err != nil {
+			return err
+		}
+		files = append(files, scanFile{absPath: absPath, csvName: filepath.ToSlash(rel)})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return scanFileList(filepath.Join(root, csvFilename), files, jobs, showProgress, fields)
+}
+
+// applyRecursive mirrors scanRecursive's layout choice, but auto-detects
+// it instead of taking --csv-mode directly: if root's bilder.csv exists
+// and its filename column contains path separators, it's a "root" CSV
+// covering the whole tree; otherwise each directory is expected to carry
+// its own bilder.csv, "per-dir" style.
+func applyRecursive(root string, jobs int, showProgress bool, opts writeOptions) error {
+	rootCSV := filepath.Join(root, csvFilename)
+	if info, err := os.Stat(rootCSV); err == nil && !info.IsDir() {
+		usesPaths, err := csvUsesPathSeparators(rootCSV)
+		if err != nil {
+			return err
+		}
+		if usesPaths {
+			return applyTitlesFromCSV(root, jobs, showProgress, opts)
+		}
+	}
+
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if _, err := os.Stat(filepath.Join(path, csvFilename)); err != nil {
+			return nil
+		}
+		return applyTitlesFromCSV(path, jobs, showProgress, opts)
+	})
+}
+
+func csvUsesPathSeparators(csvPath string) (bool, error) {
+	file, err := os.Open(csvPath)
+	if err != nil {
+		return false, fmt.Errorf("open csv: %w", err)
+	}
+	defer file.Close()
+
+	reader := newCSVReader(file)
+	header, err := reader.Read()
+	if err != nil {
+		return false, fmt.Errorf("read header: %w", err)
+	}
+	filenameIdx := headerIndex(header, "filename")
+	if filenameIdx == -1 {
+		return false, nil
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, fmt.Errorf("read record: %w", err)
+		}
+		if filenameIdx >= len(record) {
+			continue
+		}
+		if strings.ContainsAny(record[filenameIdx], `/\`) {
+			return true, nil
+		}
+	}
+	return false, nil
+}